@@ -2,6 +2,8 @@ package console
 
 import (
 	"bufio"
+	"context"
+	"io"
 	"log"
 	"os"
 	"strings"
@@ -10,13 +12,24 @@ import (
 	"github.com/netflix/hal-9001/hal"
 )
 
-type Config struct{}
+// defaultStreamFlushInterval is how often a SendStream writer flushes
+// its buffered output to Stdout as a new line when
+// Config.StreamFlushInterval is left unset.
+const defaultStreamFlushInterval = 250 * time.Millisecond
+
+type Config struct {
+	// StreamFlushInterval overrides how often SendStream flushes
+	// buffered output as a new line. Defaults to
+	// defaultStreamFlushInterval when zero.
+	StreamFlushInterval time.Duration
+}
 
 type Broker struct {
-	User   string
-	Room   string
-	Stdin  chan string
-	Stdout chan string
+	User                string
+	Room                string
+	Stdin               chan string
+	Stdout              chan string
+	StreamFlushInterval time.Duration
 }
 
 type SlashReaction string
@@ -27,11 +40,17 @@ func (c Config) NewBroker(name string) Broker {
 		user = "testuser"
 	}
 
+	flushInterval := c.StreamFlushInterval
+	if flushInterval <= 0 {
+		flushInterval = defaultStreamFlushInterval
+	}
+
 	out := Broker{
-		User:   user,
-		Room:   name,
-		Stdin:  make(chan string, 1000),
-		Stdout: make(chan string, 1000),
+		User:                user,
+		Room:                name,
+		Stdin:               make(chan string, 1000),
+		Stdout:              make(chan string, 1000),
+		StreamFlushInterval: flushInterval,
 	}
 
 	return out
@@ -50,6 +69,19 @@ func (cb Broker) SendTable(e hal.Evt, hdr []string, rows [][]string) {
 	cb.Stdout <- hal.Utf8Table(hdr, rows)
 }
 
+// SendStream has no way to edit a previously sent line on a terminal,
+// so it just appends each coalesced chunk of output as a new line.
+func (cb Broker) SendStream(e hal.Evt, ctx context.Context) (io.WriteCloser, error) {
+	w := hal.NewCoalescingWriter(cb.StreamFlushInterval, false, func(chunk string) error {
+		cb.Stdout <- chunk
+		return nil
+	})
+
+	hal.CloseOnDone(ctx, w)
+
+	return w, nil
+}
+
 // SimpleStdin will loop forever reading stdin and publish each line
 // as an event in the console broker.
 // e.g. go cbroker.SimpleStdin()
@@ -91,6 +123,7 @@ func (cb Broker) Stream(out chan *hal.Evt) {
 		input := <-cb.Stdin
 
 		e := hal.Evt{
+			ID:       hal.NewID(),
 			User:     cb.User,
 			UserId:   cb.User,
 			Room:     cb.Room,
@@ -104,16 +137,25 @@ func (cb Broker) Stream(out chan *hal.Evt) {
 		if strings.HasPrefix(e.Body, "/") {
 			args := e.BodyAsArgv()
 
-			// detect slash commands for creating specialized event types
+			// detect slash commands for creating specialized event kinds
 			switch args[0] {
-			case "/reaction":
+			case "/reaction", "/unreaction":
 				if len(args) == 2 {
 					e.Body = args[1]
 					// re-cast the reaction as a type that can be introspected by plugins
 					orig := SlashReaction(args[1])
 					e.Original = &orig
+
+					if args[0] == "/reaction" {
+						e.Kind = hal.KindReactionAdded
+					} else {
+						e.Kind = hal.KindReactionRemoved
+					}
+					e.Payload = hal.ReactionPayload{Reaction: args[1]}
+
+					out <- &e
 				} else {
-					e.Reply("/reaction requires exactly one argument!")
+					e.Reply(args[0] + " requires exactly one argument!")
 				}
 			}
 		} else {
@@ -0,0 +1,494 @@
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package matrix implements a hal.Broker backed by the Matrix
+// client-server API via mautrix-go. It supports login via access
+// token or user/password, joining and leaving rooms, DMs, and
+// formatted replies, giving hal-9001 a presence on the Matrix
+// federation the same way brokers/slack does for Slack.
+package matrix
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/netflix/hal-9001/hal"
+)
+
+// defaultStreamFlushInterval is how often a SendStream writer edits
+// its message in place with newly buffered output when
+// Config.StreamFlushInterval is left unset.
+const defaultStreamFlushInterval = 1 * time.Second
+
+// Config holds the settings required to connect to a Matrix homeserver.
+// Either AccessToken or UserId/Password is used to log in; when neither
+// AccessToken nor Password is set, NewBroker looks them up in
+// hal.DefaultSecretStore. HomeserverURL is required, e.g.
+// "https://matrix.org".
+type Config struct {
+	HomeserverURL string
+	UserId        string
+	AccessToken   string
+	Password      string
+	Rooms         []string // room IDs or aliases to join at startup
+
+	// StreamFlushInterval overrides how often SendStream edits its
+	// message in place with newly buffered output. Defaults to
+	// defaultStreamFlushInterval when zero.
+	StreamFlushInterval time.Duration
+}
+
+// Broker implements hal.Broker against a single Matrix account.
+type Broker struct {
+	client              *mautrix.Client
+	rooms               []string
+	streamFlushInterval time.Duration
+
+	mut       *sync.Mutex
+	roomNames map[id.RoomID]string          // room id -> canonical/display name
+	userNames map[id.UserID]string          // user id -> display name
+	nameRooms map[string]id.RoomID          // display name -> room id
+	nameUsers map[string]id.UserID          // display name -> user id
+	dmRooms   map[id.UserID]id.RoomID       // user id -> 1:1 room opened for SendDM
+	reactions map[id.EventID]reactionRecord // reaction event id -> what it reacted to, so a later redaction can be translated to KindReactionRemoved
+}
+
+// reactionRecord is what's cached per m.reaction event so its eventual
+// redaction (Matrix has no dedicated "un-react" event) can be reported
+// as hal.KindReactionRemoved with the same room/user/payload.
+type reactionRecord struct {
+	roomId  id.RoomID
+	userId  id.UserID
+	payload hal.ReactionPayload
+}
+
+// NewBroker logs into the homeserver and returns a ready-to-use Broker.
+// Login is performed with AccessToken when present, falling back to
+// a password login that exchanges UserId/Password for a token.
+// When neither is set in Config, the token is pulled from
+// hal.DefaultSecretStore under "matrix/token", and only if that lookup
+// fails is the password pulled from "matrix/password".
+func (c Config) NewBroker(name string) Broker {
+	if c.AccessToken == "" && c.Password == "" {
+		if token, err := hal.Secret("matrix/token"); err == nil {
+			c.AccessToken = token
+		} else if pass, err := hal.Secret("matrix/password"); err == nil {
+			c.Password = pass
+		}
+	}
+
+	client, err := mautrix.NewClient(c.HomeserverURL, "", "")
+	if err != nil {
+		log.Fatalf("matrix: could not create client for %q: %s\n", c.HomeserverURL, err)
+	}
+
+	if c.AccessToken != "" {
+		client.UserID = id.UserID(c.UserId)
+		client.AccessToken = c.AccessToken
+	} else {
+		req := &mautrix.ReqLogin{
+			Type:             "m.login.password",
+			Identifier:       mautrix.UserIdentifier{Type: "m.id.user", User: c.UserId},
+			Password:         c.Password,
+			StoreCredentials: true,
+		}
+
+		if _, err := client.Login(req); err != nil {
+			log.Fatalf("matrix: login failed for %q: %s\n", c.UserId, err)
+		}
+	}
+
+	flushInterval := c.StreamFlushInterval
+	if flushInterval <= 0 {
+		flushInterval = defaultStreamFlushInterval
+	}
+
+	out := Broker{
+		client:              client,
+		rooms:               c.Rooms,
+		streamFlushInterval: flushInterval,
+		mut:                 &sync.Mutex{},
+		roomNames:           make(map[id.RoomID]string),
+		userNames:           make(map[id.UserID]string),
+		nameRooms:           make(map[string]id.RoomID),
+		nameUsers:           make(map[string]id.UserID),
+		dmRooms:             make(map[id.UserID]id.RoomID),
+		reactions:           make(map[id.EventID]reactionRecord),
+	}
+
+	for _, room := range c.Rooms {
+		if _, err := client.JoinRoom(room, "", nil); err != nil {
+			log.Printf("matrix: could not join room %q: %s\n", room, err)
+		}
+	}
+
+	return out
+}
+
+func (mb Broker) Name() string {
+	return "matrix"
+}
+
+// Leave removes the bot from the given room.
+func (mb Broker) Leave(roomId string) error {
+	_, err := mb.client.LeaveRoom(id.RoomID(roomId))
+	return err
+}
+
+func (mb Broker) Send(e hal.Evt) {
+	if _, err := mb.client.SendText(id.RoomID(e.RoomId), e.Body); err != nil {
+		log.Printf("matrix: send to %q failed: %s\n", e.RoomId, err)
+	}
+}
+
+// SendDM sends a message to the user behind e via a 1:1 room, creating
+// one the first time a given user is DMed and reusing it on every call
+// after that.
+func (mb Broker) SendDM(e hal.Evt) {
+	uid := id.UserID(e.UserId)
+
+	mb.mut.Lock()
+	roomId, exists := mb.dmRooms[uid]
+	mb.mut.Unlock()
+
+	if !exists {
+		resp, err := mb.client.CreateRoom(&mautrix.ReqCreateRoom{
+			Preset:   "trusted_private_chat",
+			Invite:   []id.UserID{uid},
+			IsDirect: true,
+		})
+		if err != nil {
+			log.Printf("matrix: could not open DM room with %q: %s\n", e.UserId, err)
+			return
+		}
+
+		roomId = resp.RoomID
+
+		mb.mut.Lock()
+		mb.dmRooms[uid] = roomId
+		mb.mut.Unlock()
+	}
+
+	if _, err := mb.client.SendText(roomId, e.Body); err != nil {
+		log.Printf("matrix: DM send to %q failed: %s\n", e.UserId, err)
+	}
+}
+
+// SendTable renders the table as both plain-text (monospace) and
+// formatted HTML so clients that render m.notice/m.text formatted
+// bodies get an aligned table.
+func (mb Broker) SendTable(e hal.Evt, hdr []string, rows [][]string) {
+	plain := hal.Utf8Table(hdr, rows)
+	html := tableToHTML(hdr, rows)
+
+	content := event.MessageEventContent{
+		MsgType:       event.MsgText,
+		Body:          plain,
+		Format:        event.FormatHTML,
+		FormattedBody: html,
+	}
+
+	if _, err := mb.client.SendMessageEvent(id.RoomID(e.RoomId), event.EventMessage, content); err != nil {
+		log.Printf("matrix: table send to %q failed: %s\n", e.RoomId, err)
+	}
+}
+
+// SendStream posts a placeholder message and then edits it in place
+// (m.replace) with the coalesced output on every flush, so long-running
+// commands read as one message updating rather than a flood of new
+// ones.
+func (mb Broker) SendStream(e hal.Evt, ctx context.Context) (io.WriteCloser, error) {
+	resp, err := mb.client.SendText(id.RoomID(e.RoomId), "...")
+	if err != nil {
+		return nil, fmt.Errorf("matrix: could not start stream in %q: %s", e.RoomId, err)
+	}
+
+	msgId := resp.EventID
+
+	w := hal.NewCoalescingWriter(mb.streamFlushInterval, true, func(content string) error {
+		edit := event.MessageEventContent{
+			MsgType: event.MsgText,
+			Body:    "* " + content,
+			NewContent: &event.MessageEventContent{
+				MsgType: event.MsgText,
+				Body:    content,
+			},
+			RelatesTo: &event.RelatesTo{
+				Type:    event.RelReplace,
+				EventID: msgId,
+			},
+		}
+
+		_, err := mb.client.SendMessageEvent(id.RoomID(e.RoomId), event.EventMessage, edit)
+		return err
+	})
+
+	hal.CloseOnDone(ctx, w)
+
+	return w, nil
+}
+
+func tableToHTML(hdr []string, rows [][]string) string {
+	var b strings.Builder
+	b.WriteString("<table><tr>")
+	for _, h := range hdr {
+		fmt.Fprintf(&b, "<th>%s</th>", h)
+	}
+	b.WriteString("</tr>")
+
+	for _, row := range rows {
+		b.WriteString("<tr>")
+		for _, col := range row {
+			fmt.Fprintf(&b, "<td>%s</td>", col)
+		}
+		b.WriteString("</tr>")
+	}
+	b.WriteString("</table>")
+
+	return b.String()
+}
+
+// Stream publishes m.room.message events (rooms are joined by NewBroker),
+// membership changes, and reactions (both added, and removed via the
+// redaction of the original m.reaction event) as hal.Evt on the
+// provided channel until the process exits.
+func (mb Broker) Stream(out chan *hal.Evt) {
+	syncer := mb.client.Syncer.(*mautrix.DefaultSyncer)
+
+	syncer.OnEventType(event.EventMessage, func(source mautrix.EventSource, evt *event.Event) {
+		if evt.Sender == mb.client.UserID {
+			return
+		}
+
+		content := evt.Content.AsMessage()
+
+		e := hal.Evt{
+			ID:       evt.ID.String(),
+			Body:     content.Body,
+			Room:     mb.RoomIdToName(evt.RoomID.String()),
+			RoomId:   evt.RoomID.String(),
+			User:     mb.UserIdToName(evt.Sender.String()),
+			UserId:   evt.Sender.String(),
+			Time:     time.UnixMilli(evt.Timestamp),
+			Broker:   mb,
+			IsChat:   true,
+			Original: evt,
+		}
+
+		out <- &e
+	})
+
+	syncer.OnEventType(event.StateMember, func(source mautrix.EventSource, evt *event.Event) {
+		member := evt.Content.AsMember()
+
+		if evt.StateKey != nil && member.Displayname != "" {
+			mb.cacheUserName(id.UserID(*evt.StateKey), member.Displayname)
+		}
+
+		if evt.Sender == mb.client.UserID {
+			return
+		}
+
+		membership := member.Membership
+
+		var kind hal.Kind
+		switch membership {
+		case event.MembershipJoin:
+			kind = hal.KindUserJoined
+		case event.MembershipLeave, event.MembershipBan:
+			kind = hal.KindUserLeft
+		default:
+			return
+		}
+
+		// the member whose membership changed is the state key; the
+		// sender differs from it for kicks and bans
+		memberId := evt.Sender
+		if evt.StateKey != nil {
+			memberId = id.UserID(*evt.StateKey)
+		}
+
+		e := hal.Evt{
+			ID:       evt.ID.String(),
+			Room:     mb.RoomIdToName(evt.RoomID.String()),
+			RoomId:   evt.RoomID.String(),
+			User:     mb.UserIdToName(memberId.String()),
+			UserId:   memberId.String(),
+			Time:     time.UnixMilli(evt.Timestamp),
+			Broker:   mb,
+			Kind:     kind,
+			Payload:  hal.MembershipPayload{},
+			Original: evt,
+		}
+
+		out <- &e
+	})
+
+	syncer.OnEventType(event.EventReaction, func(source mautrix.EventSource, evt *event.Event) {
+		if evt.Sender == mb.client.UserID {
+			return
+		}
+
+		content := evt.Content.AsReaction()
+		payload := hal.ReactionPayload{Reaction: content.RelatesTo.Key, TargetId: content.RelatesTo.EventID.String()}
+
+		mb.mut.Lock()
+		mb.reactions[evt.ID] = reactionRecord{roomId: evt.RoomID, userId: evt.Sender, payload: payload}
+		mb.mut.Unlock()
+
+		e := hal.Evt{
+			ID:       evt.ID.String(),
+			Room:     mb.RoomIdToName(evt.RoomID.String()),
+			RoomId:   evt.RoomID.String(),
+			User:     mb.UserIdToName(evt.Sender.String()),
+			UserId:   evt.Sender.String(),
+			Time:     time.UnixMilli(evt.Timestamp),
+			Broker:   mb,
+			Kind:     hal.KindReactionAdded,
+			Payload:  payload,
+			Original: evt,
+		}
+
+		out <- &e
+	})
+
+	// Matrix has no dedicated "un-react" event: removing a reaction
+	// redacts the original m.reaction event. Translate that back into
+	// KindReactionRemoved using the reactionRecord cached above; a
+	// redaction of anything else (e.g. a deleted message) has no entry
+	// and is ignored here.
+	syncer.OnEventType(event.EventRedaction, func(source mautrix.EventSource, evt *event.Event) {
+		mb.mut.Lock()
+		rec, exists := mb.reactions[evt.Redacts]
+		if exists {
+			delete(mb.reactions, evt.Redacts)
+		}
+		mb.mut.Unlock()
+
+		if !exists {
+			return
+		}
+
+		e := hal.Evt{
+			ID:       evt.ID.String(),
+			Room:     mb.RoomIdToName(rec.roomId.String()),
+			RoomId:   rec.roomId.String(),
+			User:     mb.UserIdToName(rec.userId.String()),
+			UserId:   rec.userId.String(),
+			Time:     time.UnixMilli(evt.Timestamp),
+			Broker:   mb,
+			Kind:     hal.KindReactionRemoved,
+			Payload:  rec.payload,
+			Original: evt,
+		}
+
+		out <- &e
+	})
+
+	syncer.OnEventType(event.StateRoomName, func(source mautrix.EventSource, evt *event.Event) {
+		if name := evt.Content.AsRoomName().Name; name != "" {
+			mb.cacheRoomName(evt.RoomID, name)
+		}
+	})
+
+	syncer.OnEventType(event.StateCanonicalAlias, func(source mautrix.EventSource, evt *event.Event) {
+		if alias := evt.Content.AsCanonicalAlias().Alias; alias != "" {
+			mb.cacheRoomName(evt.RoomID, alias.String())
+		}
+	})
+
+	if err := mb.client.Sync(); err != nil {
+		log.Fatalf("matrix: sync loop exited: %s\n", err)
+	}
+}
+
+// RoomIdToName returns the cached display name for a room id, falling
+// back to the id itself when no name is known yet.
+func (mb Broker) RoomIdToName(in string) string {
+	mb.mut.Lock()
+	defer mb.mut.Unlock()
+
+	if name, exists := mb.roomNames[id.RoomID(in)]; exists {
+		return name
+	}
+
+	return in
+}
+
+// RoomNameToId is the inverse of RoomIdToName.
+func (mb Broker) RoomNameToId(in string) string {
+	mb.mut.Lock()
+	defer mb.mut.Unlock()
+
+	if rid, exists := mb.nameRooms[in]; exists {
+		return rid.String()
+	}
+
+	return in
+}
+
+// UserIdToName returns the cached display name for a Matrix user id.
+func (mb Broker) UserIdToName(in string) string {
+	mb.mut.Lock()
+	defer mb.mut.Unlock()
+
+	if name, exists := mb.userNames[id.UserID(in)]; exists {
+		return name
+	}
+
+	return in
+}
+
+// UserNameToId is the inverse of UserIdToName.
+func (mb Broker) UserNameToId(in string) string {
+	mb.mut.Lock()
+	defer mb.mut.Unlock()
+
+	if uid, exists := mb.nameUsers[in]; exists {
+		return uid.String()
+	}
+
+	return in
+}
+
+// cacheRoomName records a room id/name mapping discovered from state
+// events (m.room.name / m.room.canonical_alias) as they arrive.
+func (mb Broker) cacheRoomName(rid id.RoomID, name string) {
+	mb.mut.Lock()
+	defer mb.mut.Unlock()
+
+	mb.roomNames[rid] = name
+	mb.nameRooms[name] = rid
+}
+
+// cacheUserName records a user id/display-name mapping discovered from
+// m.room.member state events as they arrive.
+func (mb Broker) cacheUserName(uid id.UserID, name string) {
+	mb.mut.Lock()
+	defer mb.mut.Unlock()
+
+	mb.userNames[uid] = name
+	mb.nameUsers[name] = uid
+}
@@ -0,0 +1,80 @@
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package matrix
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	"maunium.net/go/mautrix/id"
+)
+
+// newTestBroker returns a Broker with its caches initialized but no
+// live client, enough to exercise the pure caching/rendering helpers
+// below.
+func newTestBroker() Broker {
+	return Broker{
+		mut:       &sync.Mutex{},
+		roomNames: make(map[id.RoomID]string),
+		userNames: make(map[id.UserID]string),
+		nameRooms: make(map[string]id.RoomID),
+		nameUsers: make(map[string]id.UserID),
+		dmRooms:   make(map[id.UserID]id.RoomID),
+	}
+}
+
+func TestCacheRoomName(t *testing.T) {
+	mb := newTestBroker()
+
+	mb.cacheRoomName("!abc:example.com", "ops")
+
+	if got := mb.RoomIdToName("!abc:example.com"); got != "ops" {
+		t.Errorf("RoomIdToName() = %q, want %q", got, "ops")
+	}
+	if got := mb.RoomNameToId("ops"); got != "!abc:example.com" {
+		t.Errorf("RoomNameToId() = %q, want %q", got, "!abc:example.com")
+	}
+
+	// unknown ids/names fall back to the input unchanged
+	if got := mb.RoomIdToName("!unknown:example.com"); got != "!unknown:example.com" {
+		t.Errorf("RoomIdToName() for unknown id = %q, want input echoed back", got)
+	}
+}
+
+func TestCacheUserName(t *testing.T) {
+	mb := newTestBroker()
+
+	mb.cacheUserName("@alice:example.com", "Alice")
+
+	if got := mb.UserIdToName("@alice:example.com"); got != "Alice" {
+		t.Errorf("UserIdToName() = %q, want %q", got, "Alice")
+	}
+	if got := mb.UserNameToId("Alice"); got != "@alice:example.com" {
+		t.Errorf("UserNameToId() = %q, want %q", got, "@alice:example.com")
+	}
+}
+
+func TestTableToHTML(t *testing.T) {
+	html := tableToHTML([]string{"a", "b"}, [][]string{{"1", "2"}})
+
+	for _, want := range []string{"<table>", "<th>a</th>", "<th>b</th>", "<td>1</td>", "<td>2</td>", "</table>"} {
+		if !strings.Contains(html, want) {
+			t.Errorf("tableToHTML() = %q, want it to contain %q", html, want)
+		}
+	}
+}
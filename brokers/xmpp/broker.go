@@ -0,0 +1,421 @@
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package xmpp implements a hal.Broker backed by XMPP, including
+// multi-user chat (MUC) support, so hal-9001 can sit in federated
+// chat networks the way brokers/slack does for Slack.
+package xmpp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mattn/go-xmpp"
+
+	"github.com/netflix/hal-9001/hal"
+)
+
+// defaultStreamFlushInterval is how often a SendStream writer flushes
+// its buffered output as a new groupchat message when
+// Config.StreamFlushInterval is left unset.
+const defaultStreamFlushInterval = 2 * time.Second
+
+// Config holds the settings required to connect to an XMPP server and
+// join a set of multi-user chats at startup.
+type Config struct {
+	Host     string // host:port, e.g. "chat.example.com:5222"
+	User     string // full JID, e.g. "hal@example.com"
+	Password string
+	Nick     string   // nickname to use in MUC rooms
+	MUCs     []string // room JIDs to join, e.g. "ops@conf.example.com"
+
+	// StreamFlushInterval overrides how often SendStream flushes
+	// buffered output as a new message. Defaults to
+	// defaultStreamFlushInterval when zero.
+	StreamFlushInterval time.Duration
+}
+
+// Broker implements hal.Broker on top of an XMPP connection using
+// SASL auth and auto-rejoining any configured MUCs on reconnect.
+type Broker struct {
+	cfg    Config
+	client *clientBox
+
+	mut       *sync.Mutex
+	roomNames map[string]string // room JID -> human name
+	nameRooms map[string]string // human name -> room JID
+	userNames map[string]string // JID -> nick/display name
+	nameUsers map[string]string // nick/display name -> JID
+}
+
+// clientBox holds the live *xmpp.Client behind a pointer that's shared
+// by every copy of Broker, so reconnect can swap in a fresh connection
+// and have it take effect everywhere the broker has been handed out
+// (e.g. bridge.Manager, plugin instances), not just in Stream's local
+// copy.
+type clientBox struct {
+	c *xmpp.Client
+}
+
+// NewBroker authenticates to the XMPP server via SASL and joins the
+// configured MUCs. When Password is unset in Config, it is pulled from
+// hal.DefaultSecretStore under "xmpp/password".
+func (c Config) NewBroker(name string) Broker {
+	if c.Password == "" {
+		if pass, err := hal.Secret("xmpp/password"); err == nil {
+			c.Password = pass
+		}
+	}
+
+	opts := xmpp.Options{
+		Host:     c.Host,
+		User:     c.User,
+		Password: c.Password,
+		NoTLS:    false,
+		StartTLS: true,
+	}
+
+	client, err := opts.NewClient()
+	if err != nil {
+		log.Fatalf("xmpp: could not connect to %q: %s\n", c.Host, err)
+	}
+
+	out := Broker{
+		cfg:       c,
+		client:    &clientBox{c: client},
+		mut:       &sync.Mutex{},
+		roomNames: make(map[string]string),
+		nameRooms: make(map[string]string),
+		userNames: make(map[string]string),
+		nameUsers: make(map[string]string),
+	}
+
+	out.joinMUCs()
+
+	return out
+}
+
+// joinMUCs sends presence to every configured MUC, which is both how
+// an XMPP client joins a room and how it rejoins after a reconnect.
+func (xb Broker) joinMUCs() {
+	for _, room := range xb.cfg.MUCs {
+		jid := fmt.Sprintf("%s/%s", room, xb.cfg.Nick)
+		if _, err := xb.conn().JoinMUCNoHistory(jid, ""); err != nil {
+			log.Printf("xmpp: could not join MUC %q: %s\n", room, err)
+		}
+	}
+}
+
+// conn returns the current underlying XMPP connection. It's indirected
+// through clientBox (rather than a plain *xmpp.Client field) so that
+// reconnect can swap the connection out from under every copy of
+// Broker, not just the one Stream is looping on.
+func (xb Broker) conn() *xmpp.Client {
+	xb.mut.Lock()
+	defer xb.mut.Unlock()
+
+	return xb.client.c
+}
+
+// streamFlushInterval returns Config.StreamFlushInterval, falling back
+// to defaultStreamFlushInterval when it wasn't set.
+func (xb Broker) streamFlushInterval() time.Duration {
+	if xb.cfg.StreamFlushInterval > 0 {
+		return xb.cfg.StreamFlushInterval
+	}
+
+	return defaultStreamFlushInterval
+}
+
+func (xb Broker) Name() string {
+	return "xmpp"
+}
+
+// Leave parts the given MUC.
+func (xb Broker) Leave(roomId string) error {
+	jid := fmt.Sprintf("%s/%s", roomId, xb.cfg.Nick)
+	_, err := xb.conn().LeaveMUC(jid)
+	return err
+}
+
+func (xb Broker) Send(e hal.Evt) {
+	if _, err := xb.conn().Send(xmpp.Chat{Remote: e.RoomId, Type: "groupchat", Text: e.Body}); err != nil {
+		log.Printf("xmpp: send to %q failed: %s\n", e.RoomId, err)
+	}
+}
+
+// SendDM delivers a 1:1 chat message to the user's bare JID.
+func (xb Broker) SendDM(e hal.Evt) {
+	if _, err := xb.conn().Send(xmpp.Chat{Remote: e.UserId, Type: "chat", Text: e.Body}); err != nil {
+		log.Printf("xmpp: DM send to %q failed: %s\n", e.UserId, err)
+	}
+}
+
+// SendTable renders the table as a monospace plain body with an
+// XHTML-IM alternative so MUC clients that support it get real table
+// markup and everyone else gets an aligned fallback.
+func (xb Broker) SendTable(e hal.Evt, hdr []string, rows [][]string) {
+	plain := hal.Utf8Table(hdr, rows)
+	xhtml := tableToXHTMLIM(hdr, rows)
+
+	chat := xmpp.Chat{
+		Remote: e.RoomId,
+		Type:   "groupchat",
+		Text:   plain,
+		Other:  []string{xhtml},
+	}
+
+	if _, err := xb.conn().Send(chat); err != nil {
+		log.Printf("xmpp: table send to %q failed: %s\n", e.RoomId, err)
+	}
+}
+
+// SendStream has no reliable message-edit support across MUC clients,
+// so it just appends each coalesced chunk of output as a new
+// groupchat message.
+func (xb Broker) SendStream(e hal.Evt, ctx context.Context) (io.WriteCloser, error) {
+	w := hal.NewCoalescingWriter(xb.streamFlushInterval(), false, func(chunk string) error {
+		_, err := xb.conn().Send(xmpp.Chat{Remote: e.RoomId, Type: "groupchat", Text: chunk})
+		return err
+	})
+
+	hal.CloseOnDone(ctx, w)
+
+	return w, nil
+}
+
+func tableToXHTMLIM(hdr []string, rows [][]string) string {
+	var b strings.Builder
+	b.WriteString(`<html xmlns='http://jabber.org/protocol/xhtml-im'><body xmlns='http://www.w3.org/1999/xhtml'><table>`)
+	b.WriteString("<tr>")
+	for _, h := range hdr {
+		fmt.Fprintf(&b, "<th>%s</th>", h)
+	}
+	b.WriteString("</tr>")
+
+	for _, row := range rows {
+		b.WriteString("<tr>")
+		for _, col := range row {
+			fmt.Fprintf(&b, "<td>%s</td>", col)
+		}
+		b.WriteString("</tr>")
+	}
+	b.WriteString("</table></body></html>")
+
+	return b.String()
+}
+
+// Stream reads chats and presence off the XMPP connection, publishing
+// MUC messages, 1:1 chats, and presence changes as hal.Evt, and using
+// presence stanzas to keep the user/room id caches warm. It reconnects
+// and rejoins all configured MUCs whenever the underlying connection
+// drops.
+func (xb Broker) Stream(out chan *hal.Evt) {
+	for {
+		chat, err := xb.conn().Recv()
+		if err != nil {
+			log.Printf("xmpp: connection lost: %s, reconnecting\n", err)
+			xb.reconnect()
+			continue
+		}
+
+		switch v := chat.(type) {
+		case xmpp.Chat:
+			if v.Text == "" || xb.isSelfEcho(v.Remote) {
+				continue
+			}
+
+			// a MUC message comes from room@service/nick; the room is
+			// the bare JID
+			roomId := v.Remote
+			if v.Type == "groupchat" {
+				roomId = bareJID(v.Remote)
+			}
+
+			e := hal.Evt{
+				ID:       hal.NewID(),
+				Body:     v.Text,
+				Room:     xb.RoomIdToName(roomId),
+				RoomId:   roomId,
+				User:     xb.UserIdToName(v.Remote),
+				UserId:   v.Remote,
+				Time:     time.Now(),
+				Broker:   xb,
+				IsChat:   true,
+				Original: &v,
+			}
+
+			out <- &e
+		case xmpp.Presence:
+			xb.cachePresence(v)
+
+			e := hal.Evt{
+				ID:       hal.NewID(),
+				Room:     xb.RoomIdToName(bareJID(v.From)),
+				RoomId:   bareJID(v.From),
+				User:     xb.UserIdToName(v.From),
+				UserId:   v.From,
+				Time:     time.Now(),
+				Broker:   xb,
+				Kind:     hal.KindPresenceChanged,
+				Payload:  hal.PresencePayload{Status: v.Show},
+				Original: &v,
+			}
+
+			out <- &e
+		}
+	}
+}
+
+// reconnect re-authenticates and rejoins all configured MUCs, storing
+// the new connection in the shared clientBox so every copy of Broker
+// (bridge.Manager, plugin instances, etc.), not just the one Stream is
+// looping on, picks it up. Called whenever Recv() reports the
+// connection dropped.
+func (xb Broker) reconnect() {
+	opts := xmpp.Options{
+		Host:     xb.cfg.Host,
+		User:     xb.cfg.User,
+		Password: xb.cfg.Password,
+		NoTLS:    false,
+		StartTLS: true,
+	}
+
+	client, err := opts.NewClient()
+	if err != nil {
+		log.Printf("xmpp: reconnect failed: %s\n", err)
+		return
+	}
+
+	xb.mut.Lock()
+	xb.client.c = client
+	xb.mut.Unlock()
+
+	xb.joinMUCs()
+}
+
+// isSelfEcho reports whether remote is this connection's own bare JID
+// (a direct-chat echo) or carries this bot's MUC nick as its resource
+// (a groupchat echo), so Stream doesn't re-ingest its own messages as
+// new inbound events.
+func (xb Broker) isSelfEcho(remote string) bool {
+	if remote == xb.cfg.User {
+		return true
+	}
+
+	if idx := strings.LastIndex(remote, "/"); idx != -1 {
+		return remote[idx+1:] == xb.cfg.Nick
+	}
+
+	return false
+}
+
+// cachePresence records the JID <-> nick mapping and the room JID <->
+// name mapping carried on MUC presence stanzas so
+// UserIdToName/UserNameToId and RoomIdToName/RoomNameToId stay cheap.
+func (xb Broker) cachePresence(p xmpp.Presence) {
+	if p.From == "" {
+		return
+	}
+
+	if p.Jid != "" {
+		// the nick is the resource of the occupant JID room@service/nick
+		nick := p.From
+		if idx := strings.LastIndex(p.From, "/"); idx != -1 {
+			nick = p.From[idx+1:]
+		}
+
+		xb.mut.Lock()
+		xb.userNames[p.Jid] = nick
+		xb.nameUsers[nick] = p.Jid
+		xb.mut.Unlock()
+	}
+
+	roomJid := bareJID(p.From)
+	name := roomLocalPart(roomJid)
+
+	xb.mut.Lock()
+	xb.roomNames[roomJid] = name
+	xb.nameRooms[name] = roomJid
+	xb.mut.Unlock()
+}
+
+// bareJID strips the resource from a full JID, e.g.
+// "ops@conf.example.com/nick" -> "ops@conf.example.com".
+func bareJID(jid string) string {
+	if idx := strings.Index(jid, "/"); idx != -1 {
+		return jid[:idx]
+	}
+	return jid
+}
+
+// roomLocalPart returns the local part of a bare room JID, e.g.
+// "ops@conf.example.com" -> "ops".
+func roomLocalPart(jid string) string {
+	if idx := strings.Index(jid, "@"); idx != -1 {
+		return jid[:idx]
+	}
+	return jid
+}
+
+func (xb Broker) RoomIdToName(in string) string {
+	xb.mut.Lock()
+	defer xb.mut.Unlock()
+
+	if name, exists := xb.roomNames[in]; exists {
+		return name
+	}
+
+	return in
+}
+
+func (xb Broker) RoomNameToId(in string) string {
+	xb.mut.Lock()
+	defer xb.mut.Unlock()
+
+	if jid, exists := xb.nameRooms[in]; exists {
+		return jid
+	}
+
+	return in
+}
+
+func (xb Broker) UserIdToName(in string) string {
+	xb.mut.Lock()
+	defer xb.mut.Unlock()
+
+	if name, exists := xb.userNames[in]; exists {
+		return name
+	}
+
+	return in
+}
+
+func (xb Broker) UserNameToId(in string) string {
+	xb.mut.Lock()
+	defer xb.mut.Unlock()
+
+	if jid, exists := xb.nameUsers[in]; exists {
+		return jid
+	}
+
+	return in
+}
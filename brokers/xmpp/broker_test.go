@@ -0,0 +1,68 @@
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package xmpp
+
+import "testing"
+
+func TestIsSelfEcho(t *testing.T) {
+	xb := Broker{cfg: Config{User: "hal@example.com", Nick: "hal"}}
+
+	cases := []struct {
+		name   string
+		remote string
+		want   bool
+	}{
+		{"muc echo of own nick", "ops@conf.example.com/hal", true},
+		{"muc message from someone else", "ops@conf.example.com/alice", false},
+		{"direct chat echo of own bare jid", "hal@example.com", true},
+		{"direct chat from someone else", "alice@example.com", false},
+		{"another user whose nick happens to differ", "ops@conf.example.com/bob", false},
+	}
+
+	for _, c := range cases {
+		if got := xb.isSelfEcho(c.remote); got != c.want {
+			t.Errorf("%s: isSelfEcho(%q) = %v, want %v", c.name, c.remote, got, c.want)
+		}
+	}
+}
+
+func TestBareJID(t *testing.T) {
+	cases := map[string]string{
+		"ops@conf.example.com/hal": "ops@conf.example.com",
+		"ops@conf.example.com":     "ops@conf.example.com",
+		"hal@example.com/laptop":   "hal@example.com",
+	}
+
+	for in, want := range cases {
+		if got := bareJID(in); got != want {
+			t.Errorf("bareJID(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestRoomLocalPart(t *testing.T) {
+	cases := map[string]string{
+		"ops@conf.example.com": "ops",
+		"ops":                  "ops",
+	}
+
+	for in, want := range cases {
+		if got := roomLocalPart(in); got != want {
+			t.Errorf("roomLocalPart(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package bridge relays events between rooms on the same or different
+// brokers, e.g. slack "#ops" <-> console "local" <-> xmpp
+// "ops@conf.example.com", the way matterbridge relays between chat
+// networks. hal-9001 can act as that relay in addition to being a bot.
+package bridge
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/netflix/hal-9001/hal"
+)
+
+// Endpoint is one side of a Bridge: a room on a broker, plus the
+// per-direction filters applied to events relayed through it.
+type Endpoint struct {
+	Broker hal.Broker
+	Room   string // room id on Broker
+	Label  string // human label used for attribution, e.g. "slack" or "ops"
+
+	DropBotEcho  bool // don't relay events with IsBot set
+	DropCommands bool // don't relay messages that look like a slash command
+}
+
+// Bridge relays events arriving from any of its Endpoints out to all
+// of the others, tagging the relayed body with the sending endpoint's
+// Label for attribution and suppressing loops via a short-lived
+// event-ID cache.
+type Bridge struct {
+	Name      string
+	Endpoints []Endpoint
+
+	seen *seenCache
+}
+
+// New returns a Bridge ready to have events handed to Relay. loopTTL
+// controls how long a relayed event's ID is remembered to stop it from
+// being bounced back and forth between endpoints.
+func New(name string, endpoints []Endpoint, loopTTL time.Duration) *Bridge {
+	return &Bridge{
+		Name:      name,
+		Endpoints: endpoints,
+		seen:      newSeenCache(loopTTL),
+	}
+}
+
+// Relay fans e out to every Endpoint other than the one it arrived on,
+// applying each destination endpoint's filters and attribution. It is
+// safe to call concurrently and safe to call with every event a broker
+// produces; events that don't match one of the bridge's endpoints are
+// ignored.
+func (b *Bridge) Relay(e hal.Evt) {
+	if e.ID != "" {
+		if b.seen.Has(e.ID) {
+			return
+		}
+		b.seen.Add(e.ID)
+	}
+
+	source := b.endpointFor(e)
+	if source == nil {
+		return
+	}
+
+	for i := range b.Endpoints {
+		dest := &b.Endpoints[i]
+		if dest == source {
+			continue
+		}
+
+		if dest.DropBotEcho && e.IsBot {
+			continue
+		}
+
+		body := e.Body
+		if dest.DropCommands && strings.HasPrefix(strings.TrimSpace(body), "/") {
+			continue
+		}
+
+		if source.Label != "" {
+			body = fmt.Sprintf("[%s:%s] %s", source.Label, e.User, body)
+		}
+
+		out := e.Clone()
+		out.ID = ""      // relayed copies get their own id from the destination broker
+		out.IsBot = true // relayed content, not a live event on dest's own endpoint
+		out.Body = body
+		out.Room = dest.Room
+		out.RoomId = dest.Room
+		out.Broker = dest.Broker
+
+		dest.Broker.Send(out)
+	}
+}
+
+// endpointFor returns the Endpoint e arrived on, matched by broker
+// name and room id, or nil if e doesn't belong to this bridge.
+func (b *Bridge) endpointFor(e hal.Evt) *Endpoint {
+	if e.Broker == nil {
+		return nil
+	}
+
+	for i := range b.Endpoints {
+		ep := &b.Endpoints[i]
+		if ep.Broker.Name() == e.Broker.Name() && ep.Room == e.RoomId {
+			return ep
+		}
+	}
+
+	return nil
+}
+
+// seenCache remembers event IDs for a bounded amount of time so a
+// relayed event can't be picked back up by the bridge and bounced
+// between endpoints forever.
+type seenCache struct {
+	ttl time.Duration
+
+	mut  sync.Mutex
+	seen map[string]time.Time
+}
+
+func newSeenCache(ttl time.Duration) *seenCache {
+	return &seenCache{
+		ttl:  ttl,
+		seen: make(map[string]time.Time),
+	}
+}
+
+func (c *seenCache) Has(id string) bool {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	seenAt, exists := c.seen[id]
+	if !exists {
+		return false
+	}
+
+	return time.Since(seenAt) < c.ttl
+}
+
+func (c *seenCache) Add(id string) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	c.seen[id] = time.Now()
+
+	// opportunistically evict old entries so the cache doesn't grow
+	// without bound in a long-running process
+	for existingId, seenAt := range c.seen {
+		if time.Since(seenAt) > c.ttl {
+			delete(c.seen, existingId)
+		}
+	}
+}
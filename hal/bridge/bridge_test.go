@@ -0,0 +1,104 @@
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package bridge
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/netflix/hal-9001/hal"
+)
+
+// fakeBroker is a minimal hal.Broker that just records what it was
+// asked to Send, for asserting on relay behavior.
+type fakeBroker struct {
+	name string
+	sent []hal.Evt
+}
+
+func (b *fakeBroker) Name() string { return b.name }
+func (b *fakeBroker) Send(e hal.Evt) {
+	b.sent = append(b.sent, e)
+}
+func (b *fakeBroker) SendDM(e hal.Evt)                                   {}
+func (b *fakeBroker) SendTable(e hal.Evt, hdr []string, rows [][]string) {}
+func (b *fakeBroker) SendStream(e hal.Evt, ctx context.Context) (io.WriteCloser, error) {
+	return nil, nil
+}
+func (b *fakeBroker) Stream(out chan *hal.Evt)      {}
+func (b *fakeBroker) Leave(roomId string) error     { return nil }
+func (b *fakeBroker) RoomIdToName(in string) string { return in }
+func (b *fakeBroker) RoomNameToId(in string) string { return in }
+func (b *fakeBroker) UserIdToName(in string) string { return in }
+func (b *fakeBroker) UserNameToId(in string) string { return in }
+
+func TestRelayDeduplicatesRepeatedId(t *testing.T) {
+	src := &fakeBroker{name: "slack"}
+	dst := &fakeBroker{name: "xmpp"}
+
+	b := New("test", []Endpoint{
+		{Broker: src, Room: "ops", Label: "slack"},
+		{Broker: dst, Room: "ops@conf.example.com", Label: "xmpp"},
+	}, time.Minute)
+
+	e := hal.Evt{ID: "evt-1", Broker: src, RoomId: "ops", User: "alice", Body: "hello"}
+
+	b.Relay(e)
+	b.Relay(e)
+
+	if len(dst.sent) != 1 {
+		t.Fatalf("expected exactly one relayed message, got %d", len(dst.sent))
+	}
+}
+
+func TestRelayTagsRelayedCopyAsBot(t *testing.T) {
+	src := &fakeBroker{name: "slack"}
+	dst := &fakeBroker{name: "xmpp"}
+
+	b := New("test", []Endpoint{
+		{Broker: src, Room: "ops", Label: "slack"},
+		{Broker: dst, Room: "ops@conf.example.com", Label: "xmpp"},
+	}, time.Minute)
+
+	e := hal.Evt{ID: "evt-1", Broker: src, RoomId: "ops", User: "alice", Body: "hello"}
+	b.Relay(e)
+
+	if len(dst.sent) != 1 {
+		t.Fatalf("expected exactly one relayed message, got %d", len(dst.sent))
+	}
+
+	if !dst.sent[0].IsBot {
+		t.Error("relayed copy should have IsBot set so a downstream bridge can drop its own echo")
+	}
+}
+
+func TestSeenCacheExpires(t *testing.T) {
+	c := newSeenCache(time.Millisecond)
+
+	c.Add("evt-1")
+	if !c.Has("evt-1") {
+		t.Fatal("expected evt-1 to be seen immediately after Add")
+	}
+
+	time.Sleep(5 * time.Millisecond)
+
+	if c.Has("evt-1") {
+		t.Fatal("expected evt-1 to have expired after ttl")
+	}
+}
@@ -0,0 +1,180 @@
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package bridge
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/netflix/hal-9001/hal"
+)
+
+const prefPlugin = "bridge"
+
+// storedEndpoint is the persisted form of an Endpoint: the broker is
+// referenced by name since hal.Broker instances aren't serializable.
+type storedEndpoint struct {
+	Broker       string `json:"broker"`
+	Room         string `json:"room"`
+	Label        string `json:"label"`
+	DropBotEcho  bool   `json:"drop_bot_echo"`
+	DropCommands bool   `json:"drop_commands"`
+}
+
+// Manager owns the set of active bridges and persists their
+// configuration to the pref store so they survive a restart.
+type Manager struct {
+	loopTTL time.Duration
+	brokers map[string]hal.Broker // broker name -> broker, for resolving persisted endpoints
+
+	mut     sync.Mutex
+	bridges map[string]*Bridge
+}
+
+// NewManager returns an empty Manager. RegisterBroker must be called
+// for every broker that bridges may reference before Load is called.
+func NewManager(loopTTL time.Duration) *Manager {
+	return &Manager{
+		loopTTL: loopTTL,
+		brokers: make(map[string]hal.Broker),
+		bridges: make(map[string]*Bridge),
+	}
+}
+
+// RegisterBroker makes a broker available to bridges by name.
+func (m *Manager) RegisterBroker(b hal.Broker) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	m.brokers[b.Name()] = b
+}
+
+// Broker looks up a previously registered broker by name.
+func (m *Manager) Broker(name string) (hal.Broker, bool) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	b, exists := m.brokers[name]
+	return b, exists
+}
+
+// Add creates or replaces a named bridge and persists its
+// configuration.
+func (m *Manager) Add(name string, endpoints []Endpoint) error {
+	m.mut.Lock()
+	m.bridges[name] = New(name, endpoints, m.loopTTL)
+	m.mut.Unlock()
+
+	return m.save(name, endpoints)
+}
+
+// Remove tears down a named bridge and removes its persisted
+// configuration.
+func (m *Manager) Remove(name string) error {
+	m.mut.Lock()
+	delete(m.bridges, name)
+	m.mut.Unlock()
+
+	pref := hal.Pref{Plugin: prefPlugin, Key: name}
+	return pref.Delete()
+}
+
+// List returns the names of all active bridges.
+func (m *Manager) List() []string {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	out := make([]string, 0, len(m.bridges))
+	for name := range m.bridges {
+		out = append(out, name)
+	}
+
+	return out
+}
+
+// Relay hands e to every active bridge; each bridge ignores events
+// that don't arrive on one of its own endpoints.
+func (m *Manager) Relay(e hal.Evt) {
+	m.mut.Lock()
+	bridges := make([]*Bridge, 0, len(m.bridges))
+	for _, b := range m.bridges {
+		bridges = append(bridges, b)
+	}
+	m.mut.Unlock()
+
+	for _, b := range bridges {
+		b.Relay(e)
+	}
+}
+
+func (m *Manager) save(name string, endpoints []Endpoint) error {
+	stored := make([]storedEndpoint, len(endpoints))
+	for i, ep := range endpoints {
+		stored[i] = storedEndpoint{
+			Broker:       ep.Broker.Name(),
+			Room:         ep.Room,
+			Label:        ep.Label,
+			DropBotEcho:  ep.DropBotEcho,
+			DropCommands: ep.DropCommands,
+		}
+	}
+
+	data, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+
+	pref := hal.Pref{Plugin: prefPlugin, Key: name, Value: string(data)}
+	return pref.Set()
+}
+
+// Load reads every persisted bridge back out of the pref store and
+// starts it, resolving each endpoint's broker name via RegisterBroker.
+func (m *Manager) Load() error {
+	prefs := hal.FindPrefs("", "", "", prefPlugin, "")
+
+	for _, pref := range prefs {
+		var stored []storedEndpoint
+		if err := json.Unmarshal([]byte(pref.Value), &stored); err != nil {
+			return fmt.Errorf("could not load bridge %q: %s", pref.Key, err)
+		}
+
+		endpoints := make([]Endpoint, 0, len(stored))
+		for _, se := range stored {
+			broker, exists := m.brokers[se.Broker]
+			if !exists {
+				return fmt.Errorf("bridge %q references unknown broker %q", pref.Key, se.Broker)
+			}
+
+			endpoints = append(endpoints, Endpoint{
+				Broker:       broker,
+				Room:         se.Room,
+				Label:        se.Label,
+				DropBotEcho:  se.DropBotEcho,
+				DropCommands: se.DropCommands,
+			})
+		}
+
+		m.mut.Lock()
+		m.bridges[pref.Key] = New(pref.Key, endpoints, m.loopTTL)
+		m.mut.Unlock()
+	}
+
+	return nil
+}
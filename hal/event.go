@@ -17,6 +17,8 @@ package hal
  */
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"regexp"
 	"strings"
@@ -40,6 +42,8 @@ type Evt struct {
 	UserId    string       `json:"user_id"` // the user id from the source broker
 	Time      time.Time    `json:"time"`    // timestamp of the event
 	Broker    Broker       `json:"broker"`  // the broker the event came from
+	Kind      Kind         `json:"kind"`    // the kind of event, e.g. reaction/join/typing; "" for a plain message
+	Payload   interface{}  `json:"payload"` // typed data associated with Kind, e.g. ReactionPayload
 	IsChat    bool         `json:"is_chat"` // lets the broker differentiate chats and other events
 	IsBot     bool         `json:"is_bot"`  // message was generated by the bot
 	ToUser    bool         `json:"to_user"` // when true, always deliver outgoing event via DM
@@ -50,6 +54,20 @@ type Evt struct {
 	instance  *Instance    // used by the broker to provide plugin instance metadata
 }
 
+// NewID returns a short random hex string for use as an Evt.ID by
+// brokers whose underlying protocol doesn't hand them one (e.g.
+// console, XMPP chat/presence stanzas), so features that key off
+// Evt.ID, such as the bridge's loop-suppression cache, work regardless
+// of the source broker.
+func NewID() string {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+
+	return hex.EncodeToString(b[:])
+}
+
 // Clone() returns a copy of the event with the same broker/room/user
 // and a current timestamp. Body, Command, Subject, and Original will be empty.
 func (e *Evt) Clone() Evt {
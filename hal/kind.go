@@ -0,0 +1,87 @@
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package hal
+
+// Kind classifies what an Evt represents. The zero value, KindMessage,
+// is an ordinary chat message and is what every broker produced before
+// Kind existed, so brokers and plugins that never look at Kind keep
+// working unchanged.
+type Kind string
+
+const (
+	// KindMessage is a plain chat message. This is the zero value so
+	// that brokers which don't set Kind still behave as before.
+	KindMessage Kind = ""
+
+	KindReactionAdded   Kind = "reaction_added"
+	KindReactionRemoved Kind = "reaction_removed"
+	KindUserJoined      Kind = "user_joined"
+	KindUserLeft        Kind = "user_left"
+	KindTyping          Kind = "typing"
+	KindPresenceChanged Kind = "presence_changed"
+	KindMessageEdited   Kind = "message_edited"
+	KindMessageDeleted  Kind = "message_deleted"
+)
+
+// Is returns true when the event's Kind matches k, making it
+// convenient for plugins/router matchers to filter by kind, e.g.
+// router.On(func(e *hal.Evt) bool { return e.Is(hal.KindReactionAdded) }, ...).
+func (e *Evt) Is(k Kind) bool {
+	return e.Kind == k
+}
+
+// ReactionPayload is the Evt.Payload for KindReactionAdded and
+// KindReactionRemoved events. TargetId identifies the message the
+// reaction applies to, in whatever form the originating broker uses
+// for message ids.
+type ReactionPayload struct {
+	Reaction string
+	TargetId string
+}
+
+// MembershipPayload is the Evt.Payload for KindUserJoined and
+// KindUserLeft events. The user/room themselves are already on Evt
+// via User/UserId/Room/RoomId; Reason carries an optional broker
+// supplied explanation, e.g. a MUC part message or a kick reason.
+type MembershipPayload struct {
+	Reason string
+}
+
+// TypingPayload is the Evt.Payload for KindTyping events.
+type TypingPayload struct {
+	IsTyping bool
+}
+
+// PresencePayload is the Evt.Payload for KindPresenceChanged events.
+// Status is broker-specific, e.g. "online", "away", "offline", "dnd".
+type PresencePayload struct {
+	Status string
+}
+
+// MessageEditPayload is the Evt.Payload for KindMessageEdited events.
+// TargetId is the id of the message being replaced and NewBody is the
+// replacement text; Evt.Body continues to carry the original text.
+type MessageEditPayload struct {
+	TargetId string
+	NewBody  string
+}
+
+// MessageDeletePayload is the Evt.Payload for KindMessageDeleted
+// events. TargetId is the id of the message that was removed.
+type MessageDeletePayload struct {
+	TargetId string
+}
@@ -0,0 +1,148 @@
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package hal
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// SecretStore is the interface brokers and plugins use to fetch tokens,
+// API keys, and per-room credentials instead of reading them directly
+// out of the environment. DefaultSecretStore is the instance consulted
+// by Secret() below.
+type SecretStore interface {
+	// Get returns the secret stored at path.
+	Get(path string) (string, error)
+	// Put writes value to path, creating it if necessary.
+	Put(path, value string) error
+	// List returns the names of secrets/folders immediately under path.
+	List(path string) ([]string, error)
+	// Watch polls path every interval and sends the value on the
+	// returned channel whenever it changes. Reads that fail are
+	// skipped and polling continues; failures do not close the channel.
+	Watch(path string, interval time.Duration) (<-chan string, error)
+}
+
+// DefaultSecretStore is consulted by Secret() and friends. It defaults
+// to an EnvFileStore rooted at $HAL_SECRETS_DIR (if set) so existing
+// deployments that rely on plain environment variables keep working.
+var DefaultSecretStore SecretStore = EnvFileStore{Dir: os.Getenv("HAL_SECRETS_DIR")}
+
+// Secret is a convenience wrapper around DefaultSecretStore.Get.
+func Secret(path string) (string, error) {
+	return DefaultSecretStore.Get(path)
+}
+
+// EnvFileStore is the default SecretStore. It looks up path as an
+// environment variable first (with "/" replaced by "_" and upper-cased,
+// e.g. "slack/token" -> "SLACK_TOKEN"), then falls back to a file named
+// path under Dir, if Dir is set.
+type EnvFileStore struct {
+	Dir string
+}
+
+func envKey(path string) string {
+	key := strings.ToUpper(strings.Replace(path, "/", "_", -1))
+	return strings.Replace(key, "-", "_", -1)
+}
+
+func (s EnvFileStore) Get(path string) (string, error) {
+	if v := os.Getenv(envKey(path)); v != "" {
+		return v, nil
+	}
+
+	if s.Dir == "" {
+		return "", fmt.Errorf("secret %q not found in environment and no file store configured", path)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(s.Dir, path))
+	if err != nil {
+		return "", fmt.Errorf("secret %q not found: %s", path, err)
+	}
+
+	return strings.TrimSpace(string(data)), nil
+}
+
+func (s EnvFileStore) Put(path, value string) error {
+	if s.Dir == "" {
+		return fmt.Errorf("EnvFileStore has no Dir configured, cannot write %q", path)
+	}
+
+	full := filepath.Join(s.Dir, path)
+	if err := os.MkdirAll(filepath.Dir(full), 0700); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(full, []byte(value), 0600)
+}
+
+func (s EnvFileStore) List(path string) ([]string, error) {
+	if s.Dir == "" {
+		return nil, fmt.Errorf("EnvFileStore has no Dir configured, cannot list %q", path)
+	}
+
+	entries, err := ioutil.ReadDir(filepath.Join(s.Dir, path))
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]string, len(entries))
+	for i, entry := range entries {
+		out[i] = entry.Name()
+	}
+
+	return out, nil
+}
+
+// Watch polls the file backing path for changes. When Dir is unset
+// there is no file to poll, so the returned channel is closed
+// immediately. Environment variables are not watched.
+func (s EnvFileStore) Watch(path string, interval time.Duration) (<-chan string, error) {
+	out := make(chan string)
+
+	if s.Dir == "" {
+		close(out)
+		return out, nil
+	}
+
+	full := filepath.Join(s.Dir, path)
+
+	go func() {
+		defer close(out)
+
+		var last string
+		for {
+			data, err := ioutil.ReadFile(full)
+			if err == nil {
+				cur := strings.TrimSpace(string(data))
+				if cur != last {
+					last = cur
+					out <- cur
+				}
+			}
+
+			time.Sleep(interval)
+		}
+	}()
+
+	return out, nil
+}
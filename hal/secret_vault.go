@@ -0,0 +1,300 @@
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package hal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// VaultStore is a SecretStore backed by a HashiCorp Vault server. It
+// supports both KV v1 and KV v2 mounts, auto-detecting which version a
+// given mount uses and rewriting request paths accordingly.
+type VaultStore struct {
+	Addr  string // e.g. "https://vault.example.com:8200"
+	Token string
+
+	client *http.Client
+
+	mut           sync.Mutex
+	mountVersions map[string]int // mount -> 1 or 2, cached after first lookup
+}
+
+// NewVaultStore returns a VaultStore ready to use and starts a
+// background goroutine that renews Token every renewInterval via
+// auth/token/renew-self. Pass a zero renewInterval to disable renewal.
+func NewVaultStore(addr, token string, renewInterval time.Duration) *VaultStore {
+	vs := &VaultStore{
+		Addr:          strings.TrimRight(addr, "/"),
+		Token:         token,
+		client:        &http.Client{Timeout: 10 * time.Second},
+		mountVersions: make(map[string]int),
+	}
+
+	if renewInterval > 0 {
+		go vs.renewLoop(renewInterval)
+	}
+
+	return vs
+}
+
+func (vs *VaultStore) renewLoop(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+
+		if err := vs.renewSelf(); err != nil {
+			log.Printf("hal: vault token renewal failed: %s\n", err)
+		}
+	}
+}
+
+func (vs *VaultStore) renewSelf() error {
+	_, err := vs.request("POST", "auth/token/renew-self", nil)
+	return err
+}
+
+// mountOf returns the first path element of path, which Vault treats
+// as the mount name, e.g. mountOf("secret/foo/bar") == "secret".
+func mountOf(path string) string {
+	path = strings.TrimPrefix(path, "/")
+	if idx := strings.Index(path, "/"); idx != -1 {
+		return path[:idx]
+	}
+	return path
+}
+
+// mountVersion returns 1 or 2 for the KV mount backing path, querying
+// and caching sys/internal/ui/mounts/<mount> on first use.
+func (vs *VaultStore) mountVersion(path string) (int, error) {
+	mount := mountOf(path)
+
+	vs.mut.Lock()
+	if v, exists := vs.mountVersions[mount]; exists {
+		vs.mut.Unlock()
+		return v, nil
+	}
+	vs.mut.Unlock()
+
+	body, err := vs.request("GET", fmt.Sprintf("sys/internal/ui/mounts/%s", mount), nil)
+	if err != nil {
+		return 0, fmt.Errorf("could not determine KV version for mount %q: %s", mount, err)
+	}
+
+	var resp struct {
+		Data struct {
+			Options struct {
+				Version string `json:"version"`
+			} `json:"options"`
+		} `json:"data"`
+	}
+
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return 0, fmt.Errorf("could not parse mount info for %q: %s", mount, err)
+	}
+
+	version := 1
+	if resp.Data.Options.Version == "2" {
+		version = 2
+	}
+
+	vs.mut.Lock()
+	vs.mountVersions[mount] = version
+	vs.mut.Unlock()
+
+	return version, nil
+}
+
+// rewritePath inserts the KV v2 "data" or "metadata" segment right
+// after the mount name, e.g. "secret/foo" -> "secret/data/foo".
+func rewritePath(path, segment string) string {
+	mount := mountOf(path)
+	rest := strings.TrimPrefix(strings.TrimPrefix(path, mount), "/")
+
+	if rest == "" {
+		return fmt.Sprintf("%s/%s", mount, segment)
+	}
+
+	return fmt.Sprintf("%s/%s/%s", mount, segment, rest)
+}
+
+func (vs *VaultStore) Get(path string) (string, error) {
+	version, err := vs.mountVersion(path)
+	if err != nil {
+		return "", err
+	}
+
+	readPath := path
+	if version == 2 {
+		readPath = rewritePath(path, "data")
+	}
+
+	body, err := vs.request("GET", readPath, nil)
+	if err != nil {
+		return "", fmt.Errorf("could not read secret %q: %s", path, err)
+	}
+
+	var resp struct {
+		Data map[string]interface{} `json:"data"`
+	}
+
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return "", fmt.Errorf("could not parse secret %q: %s", path, err)
+	}
+
+	data := resp.Data
+
+	// KV v2 wraps the real payload in an extra "data" envelope, e.g.
+	// {"data": {"data": {"value": "..."}, "metadata": {...}}}
+	if version == 2 {
+		if inner, ok := data["data"].(map[string]interface{}); ok {
+			data = inner
+		}
+	}
+
+	if v, ok := data["value"]; ok {
+		return fmt.Sprintf("%v", v), nil
+	}
+
+	return "", fmt.Errorf("secret %q has no \"value\" key", path)
+}
+
+func (vs *VaultStore) Put(path, value string) error {
+	version, err := vs.mountVersion(path)
+	if err != nil {
+		return err
+	}
+
+	writePath := path
+	payload := map[string]interface{}{"value": value}
+
+	if version == 2 {
+		writePath = rewritePath(path, "data")
+		payload = map[string]interface{}{"data": payload}
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	_, err = vs.request("POST", writePath, body)
+	return err
+}
+
+func (vs *VaultStore) List(path string) ([]string, error) {
+	version, err := vs.mountVersion(path)
+	if err != nil {
+		return nil, err
+	}
+
+	listPath := path
+	if version == 2 {
+		listPath = rewritePath(path, "metadata")
+	}
+
+	body, err := vs.request("LIST", listPath, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not list %q: %s", path, err)
+	}
+
+	var resp struct {
+		Data struct {
+			Keys []string `json:"keys"`
+		} `json:"data"`
+	}
+
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("could not parse list response for %q: %s", path, err)
+	}
+
+	return resp.Data.Keys, nil
+}
+
+// Watch polls path every interval via Get and sends the value on the
+// returned channel whenever it changes.
+func (vs *VaultStore) Watch(path string, interval time.Duration) (<-chan string, error) {
+	out := make(chan string)
+
+	go func() {
+		defer close(out)
+
+		var last string
+		for {
+			cur, err := vs.Get(path)
+			if err == nil && cur != last {
+				last = cur
+				out <- cur
+			}
+
+			time.Sleep(interval)
+		}
+	}()
+
+	return out, nil
+}
+
+// request issues an HTTP request against the Vault API at
+// Addr + "/v1/" + path, attaching the auth token, and returns the
+// response body. LIST is sent as GET with ?list=true per Vault's API,
+// since net/http's client does not special-case the verb.
+func (vs *VaultStore) request(method, path string, body []byte) ([]byte, error) {
+	url := fmt.Sprintf("%s/v1/%s", vs.Addr, strings.TrimPrefix(path, "/"))
+
+	var reqBody *bytes.Reader
+	if body != nil {
+		reqBody = bytes.NewReader(body)
+	} else {
+		reqBody = bytes.NewReader([]byte{})
+	}
+
+	httpMethod := method
+	if method == "LIST" {
+		httpMethod = "GET"
+		url = url + "?list=true"
+	}
+
+	req, err := http.NewRequest(httpMethod, url, reqBody)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", vs.Token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := vs.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	out, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("vault returned %s: %s", resp.Status, string(out))
+	}
+
+	return out, nil
+}
@@ -0,0 +1,51 @@
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package hal
+
+import "testing"
+
+func TestMountOf(t *testing.T) {
+	cases := map[string]string{
+		"secret/foo/bar": "secret",
+		"secret/foo":     "secret",
+		"secret":         "secret",
+		"/secret/foo":    "secret",
+	}
+
+	for in, want := range cases {
+		if got := mountOf(in); got != want {
+			t.Errorf("mountOf(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestRewritePath(t *testing.T) {
+	cases := []struct {
+		path, segment, want string
+	}{
+		{"secret/foo", "data", "secret/data/foo"},
+		{"secret/foo/bar", "data", "secret/data/foo/bar"},
+		{"secret", "data", "secret/data"},
+		{"secret/foo", "metadata", "secret/metadata/foo"},
+	}
+
+	for _, c := range cases {
+		if got := rewritePath(c.path, c.segment); got != c.want {
+			t.Errorf("rewritePath(%q, %q) = %q, want %q", c.path, c.segment, got, c.want)
+		}
+	}
+}
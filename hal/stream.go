@@ -0,0 +1,137 @@
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package hal
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// ReplyStream returns a writer whose contents are coalesced and
+// flushed to the source channel at most once per broker-defined
+// interval, rather than as a flood of individual replies. This is
+// meant for long-running commands (shell/deploy plugins, etc) that
+// want to stream progressive output. The stream ends, flushing
+// anything buffered, when the returned writer is closed or ctx is
+// canceled, whichever happens first.
+func (e *Evt) ReplyStream(ctx context.Context) (io.WriteCloser, error) {
+	if e.Broker == nil {
+		return nil, fmt.Errorf("hal.Evt.ReplyStream called with nil Broker")
+	}
+
+	return e.Broker.SendStream(e.Clone(), ctx)
+}
+
+// CoalescingWriter buffers Write() calls and hands the accumulated
+// text to flush at most once per minInterval, so a broker can turn a
+// stream of small writes into a manageable number of chat messages or
+// message edits.
+//
+// When keep is true the buffer is kept after a flush and flush always
+// receives everything written so far -- the shape a broker needs to
+// edit a single message in place (Slack chat.update, Matrix
+// m.replace). When keep is false the buffer is drained on flush and
+// flush only receives what's new since the last call -- the shape a
+// broker needs to append a new message per flush.
+type CoalescingWriter struct {
+	minInterval time.Duration
+	keep        bool
+	flush       func(chunk string) error
+
+	mut       sync.Mutex
+	buf       bytes.Buffer
+	lastFlush time.Time
+	closed    bool
+}
+
+// NewCoalescingWriter returns a ready-to-use CoalescingWriter. See the
+// type doc for what keep controls.
+func NewCoalescingWriter(minInterval time.Duration, keep bool, flush func(chunk string) error) *CoalescingWriter {
+	return &CoalescingWriter{
+		minInterval: minInterval,
+		keep:        keep,
+		flush:       flush,
+		lastFlush:   time.Now(),
+	}
+}
+
+func (w *CoalescingWriter) Write(p []byte) (int, error) {
+	w.mut.Lock()
+	if w.closed {
+		w.mut.Unlock()
+		return 0, fmt.Errorf("write to closed CoalescingWriter")
+	}
+	w.buf.Write(p)
+	due := time.Since(w.lastFlush) >= w.minInterval
+	w.mut.Unlock()
+
+	if due {
+		if err := w.doFlush(); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(p), nil
+}
+
+// doFlush hands the buffered content to flush, then resets the buffer
+// unless keep is set.
+func (w *CoalescingWriter) doFlush() error {
+	w.mut.Lock()
+	content := w.buf.String()
+	w.lastFlush = time.Now()
+	if !w.keep {
+		w.buf.Reset()
+	}
+	w.mut.Unlock()
+
+	if content == "" {
+		return nil
+	}
+
+	return w.flush(content)
+}
+
+// Close flushes any remaining buffered content, ignoring minInterval,
+// and marks the writer closed. Closing an already closed writer does
+// nothing.
+func (w *CoalescingWriter) Close() error {
+	w.mut.Lock()
+	if w.closed {
+		w.mut.Unlock()
+		return nil
+	}
+	w.closed = true
+	w.mut.Unlock()
+
+	return w.doFlush()
+}
+
+// CloseOnDone spawns a goroutine that closes w when ctx is canceled,
+// for brokers whose SendStream wants ReplyStream's caller to be able
+// to stop the stream by canceling its context instead of remembering
+// to call Close.
+func CloseOnDone(ctx context.Context, w io.Closer) {
+	go func() {
+		<-ctx.Done()
+		w.Close()
+	}()
+}
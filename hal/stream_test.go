@@ -0,0 +1,76 @@
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package hal
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCoalescingWriterKeepFlushesFullBuffer(t *testing.T) {
+	var flushes []string
+	w := NewCoalescingWriter(time.Hour, true, func(chunk string) error {
+		flushes = append(flushes, chunk)
+		return nil
+	})
+
+	w.Write([]byte("hello "))
+	w.Write([]byte("world"))
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() returned %s", err)
+	}
+
+	if len(flushes) != 1 || flushes[0] != "hello world" {
+		t.Fatalf("expected a single flush of the full buffer, got %#v", flushes)
+	}
+}
+
+func TestCoalescingWriterNoKeepDrainsBetweenFlushes(t *testing.T) {
+	var flushes []string
+	// a zero minInterval makes every Write due, so each one flushes
+	w := NewCoalescingWriter(0, false, func(chunk string) error {
+		flushes = append(flushes, chunk)
+		return nil
+	})
+
+	if _, err := w.Write([]byte("first")); err != nil {
+		t.Fatalf("Write() returned %s", err)
+	}
+	if _, err := w.Write([]byte("second")); err != nil {
+		t.Fatalf("Write() returned %s", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() returned %s", err)
+	}
+
+	if len(flushes) != 2 || flushes[0] != "first" || flushes[1] != "second" {
+		t.Fatalf("expected each flush to carry only what was written since the last one, got %#v", flushes)
+	}
+}
+
+func TestCoalescingWriterRejectsWriteAfterClose(t *testing.T) {
+	w := NewCoalescingWriter(time.Hour, false, func(chunk string) error { return nil })
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() returned %s", err)
+	}
+
+	if _, err := w.Write([]byte("too late")); err == nil {
+		t.Fatal("expected Write after Close to return an error")
+	}
+}
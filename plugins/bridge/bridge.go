@@ -0,0 +1,122 @@
+/*
+ * Copyright 2016 Albert P. Tobey <atobey@netflix.com>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package bridge is the chat-facing half of hal/bridge: it exposes
+// "bridge add/remove/list" commands so operators can wire up and tear
+// down cross-broker relays at runtime instead of editing static
+// config.
+package bridge
+
+import (
+	"strings"
+	"time"
+
+	"github.com/netflix/hal-9001/hal"
+	halbridge "github.com/netflix/hal-9001/hal/bridge"
+)
+
+// Manager holds every bridge configured through this plugin (or loaded
+// from the pref store at startup via Manager.Load). Brokers must be
+// registered with Manager.RegisterBroker before plugins or Load can
+// reference them by name.
+var Manager = halbridge.NewManager(5 * time.Minute)
+
+// Plugin registers the "bridge" command with the router.
+var Plugin = hal.Plugin{
+	Name: "bridge",
+	Func: bridgeCmd,
+}
+
+func bridgeCmd(e *hal.Evt) {
+	argv := e.BodyAsArgv()
+	if len(argv) < 2 {
+		e.Reply("usage: bridge <add|remove|list>")
+		return
+	}
+
+	switch argv[1] {
+	case "list":
+		list(e)
+	case "add":
+		add(e, argv[2:])
+	case "remove":
+		remove(e, argv[2:])
+	default:
+		e.Replyf("unknown bridge subcommand %q", argv[1])
+	}
+}
+
+func list(e *hal.Evt) {
+	names := Manager.List()
+	if len(names) == 0 {
+		e.Reply("no bridges configured")
+		return
+	}
+
+	e.Reply(strings.Join(names, "\n"))
+}
+
+// add handles: bridge add <name> <broker>:<room> <broker>:<room> [...]
+func add(e *hal.Evt, args []string) {
+	if len(args) < 3 {
+		e.Reply("usage: bridge add <name> <broker>:<room> <broker>:<room> [...]")
+		return
+	}
+
+	name := args[0]
+	endpoints := make([]halbridge.Endpoint, 0, len(args)-1)
+
+	for _, spec := range args[1:] {
+		parts := strings.SplitN(spec, ":", 2)
+		if len(parts) != 2 {
+			e.Replyf("invalid endpoint %q, want broker:room", spec)
+			return
+		}
+
+		broker, exists := Manager.Broker(parts[0])
+		if !exists {
+			e.Replyf("unknown broker %q", parts[0])
+			return
+		}
+
+		endpoints = append(endpoints, halbridge.Endpoint{
+			Broker: broker,
+			Room:   parts[1],
+			Label:  parts[0],
+		})
+	}
+
+	if err := Manager.Add(name, endpoints); err != nil {
+		e.Error(err)
+		return
+	}
+
+	e.Replyf("bridge %q created with %d endpoints", name, len(endpoints))
+}
+
+func remove(e *hal.Evt, args []string) {
+	if len(args) != 1 {
+		e.Reply("usage: bridge remove <name>")
+		return
+	}
+
+	if err := Manager.Remove(args[0]); err != nil {
+		e.Error(err)
+		return
+	}
+
+	e.Replyf("bridge %q removed", args[0])
+}